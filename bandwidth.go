@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// bandwidthWindow is how far back the rolling meter looks.
+const bandwidthWindow = 10 * time.Second
+
+type bandwidthSample struct {
+	at    time.Time
+	bytes int
+}
+
+// BandwidthMeter tracks bytes transferred over a rolling window per room,
+// so a GET /games/{id}/bw caller sees live throughput rather than a
+// lifetime total that never reflects the current match.
+type BandwidthMeter struct {
+	mu sync.Mutex
+	tx []bandwidthSample
+	rx []bandwidthSample
+}
+
+// RecordTx records n bytes sent to clients.
+func (m *BandwidthMeter) RecordTx(n int) { m.record(&m.tx, n) }
+
+// RecordRx records n bytes received from clients.
+func (m *BandwidthMeter) RecordRx(n int) { m.record(&m.rx, n) }
+
+func (m *BandwidthMeter) record(samples *[]bandwidthSample, n int) {
+	m.mu.Lock()
+	*samples = append(prune(*samples), bandwidthSample{at: time.Now(), bytes: n})
+	m.mu.Unlock()
+}
+
+func prune(samples []bandwidthSample) []bandwidthSample {
+	cutoff := time.Now().Add(-bandwidthWindow)
+	kept := samples[:0]
+	for _, s := range samples {
+		if s.at.After(cutoff) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// BandwidthStats is the GET /games/{id}/bw response payload.
+type BandwidthStats struct {
+	WindowSeconds float64 `json:"windowSeconds"`
+	TxBytes       int     `json:"txBytes"`
+	RxBytes       int     `json:"rxBytes"`
+	TxBytesPerSec float64 `json:"txBytesPerSec"`
+	RxBytesPerSec float64 `json:"rxBytesPerSec"`
+}
+
+// Stats summarizes the current window.
+func (m *BandwidthMeter) Stats() BandwidthStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tx = prune(m.tx)
+	m.rx = prune(m.rx)
+
+	txTotal, rxTotal := 0, 0
+	for _, s := range m.tx {
+		txTotal += s.bytes
+	}
+	for _, s := range m.rx {
+		rxTotal += s.bytes
+	}
+
+	window := bandwidthWindow.Seconds()
+	return BandwidthStats{
+		WindowSeconds: window,
+		TxBytes:       txTotal,
+		RxBytes:       rxTotal,
+		TxBytesPerSec: float64(txTotal) / window,
+		RxBytesPerSec: float64(rxTotal) / window,
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+// Subprotocols the server understands, negotiated during the WebSocket
+// upgrade via Sec-WebSocket-Protocol. Binary trades a slightly fussier
+// wire format for meaningfully less bandwidth per tick; JSON stays
+// available for clients that just want to read frames in a dev console.
+const (
+	protocolBinary = "gamae-binary-v1"
+	protocolJSON   = "gamae-json-v1"
+)
+
+var supportedSubprotocols = []string{protocolBinary, protocolJSON}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	buf.Write(tmp[:n])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func writeFloat64(buf *bytes.Buffer, f float64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(f))
+	buf.Write(tmp[:])
+}
+
+// encodeBinary packs a snapshotDelta into a compact wire format: varint
+// length-prefixed IDs and little-endian float64s, avoiding both JSON's
+// per-field key overhead and text-formatted floats.
+//
+//	tick        varint
+//	numPlayers  varint
+//	player*     id(string) x(f64) y(f64) dead(byte) lastAckedSeq(varint) kills(varint) deaths(varint)
+//	numProj     varint
+//	proj*       id(string) owner(string) x(f64) y(f64) dx(f64) dy(f64) speed(f64)
+//	numRemovedPlayers     varint
+//	removedPlayer*        id(string)
+//	numRemovedProjectiles varint
+//	removedProjectile*    id(string)
+func encodeBinary(d snapshotDelta) []byte {
+	var buf bytes.Buffer
+
+	writeUvarint(&buf, d.Tick)
+
+	writeUvarint(&buf, uint64(len(d.Players)))
+	for _, p := range d.Players {
+		writeString(&buf, p.ID)
+		writeFloat64(&buf, p.X)
+		writeFloat64(&buf, p.Y)
+		if p.Dead {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+		writeUvarint(&buf, p.LastAckedSeq)
+		writeUvarint(&buf, uint64(p.Kills))
+		writeUvarint(&buf, uint64(p.Deaths))
+	}
+
+	writeUvarint(&buf, uint64(len(d.Projectiles)))
+	for _, proj := range d.Projectiles {
+		writeString(&buf, proj.ID)
+		writeString(&buf, proj.Owner)
+		writeFloat64(&buf, proj.X)
+		writeFloat64(&buf, proj.Y)
+		writeFloat64(&buf, proj.DX)
+		writeFloat64(&buf, proj.DY)
+		writeFloat64(&buf, proj.Speed)
+	}
+
+	writeUvarint(&buf, uint64(len(d.RemovedPlayers)))
+	for _, id := range d.RemovedPlayers {
+		writeString(&buf, id)
+	}
+
+	writeUvarint(&buf, uint64(len(d.RemovedProjectiles)))
+	for _, id := range d.RemovedProjectiles {
+		writeString(&buf, id)
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	tickRate           = 60
+	tickInterval       = time.Second / tickRate
+	playerSpeed        = 200.0 // units per second
+	projectileLifetime = 5 * time.Second
+
+	// removalRetentionTicks bounds how long a removed entity's tombstone is
+	// kept around for delta snapshots, well beyond any reasonable ack
+	// latency, so the slice can't grow without bound.
+	removalRetentionTicks = 300 // ~5s at tickRate
+)
+
+// removal is a tombstone recording when an entity left the room, so a
+// connection that hasn't acked past that tick yet still finds out.
+type removal struct {
+	ID   string
+	Tick uint64
+}
+
+// run is the room's single authoritative tick loop. Each tick it drains
+// every player's queued inputs, integrates movement and shots, advances
+// projectiles, resolves collisions, and broadcasts one delta snapshot per
+// connection. This replaces the old pair of free-running 16ms goroutines
+// that raced on the same mutex with no shared notion of "this tick".
+func (r *Room) run() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		tickStart := time.Now()
+
+		r.Mutex.Lock()
+		r.Tick++
+		r.applyInputs()
+		r.advanceProjectiles()
+		r.pruneRemovals()
+		endRound, winner := r.Mode.OnTick(r)
+		playersLeft := len(r.Players)
+		if playersLeft == 0 {
+			r.started = false
+		}
+		r.Mutex.Unlock()
+
+		if playersLeft == 0 {
+			return
+		}
+
+		r.broadcastSnapshot()
+		r.frameStats.record(time.Since(tickStart))
+
+		if endRound {
+			r.endRound(winner)
+		}
+	}
+}
+
+// snapshotCounts returns the player counts and current tick under a single
+// lock acquisition, for the stats endpoints.
+func (r *Room) snapshotCounts() (total, active int, tick uint64) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	total = len(r.Players)
+	for _, player := range r.Players {
+		if !player.Dead {
+			active++
+		}
+	}
+	return total, active, r.Tick
+}
+
+// nextChangeTick stamps a change made outside the tick loop's own
+// processing (a join, a respawn reset, a grace-period removal) so it's
+// picked up by the very next broadcast rather than being mistaken for
+// something already covered by the tick that just went out.
+func (r *Room) nextChangeTick() uint64 {
+	return r.Tick + 1
+}
+
+// applyInputs drains every player's pending input queue and integrates it
+// for this tick, recording the last sequence number applied so the
+// snapshot lets clients reconcile their prediction. Must be called with
+// r.Mutex held.
+func (r *Room) applyInputs() {
+	dt := tickInterval.Seconds()
+	for _, player := range r.Players {
+		for drained := false; !drained; {
+			select {
+			case action := <-player.inputs:
+				r.applyMove(player, action.Move, dt)
+				r.applyShoot(player, action.Shoot)
+				player.LastAckedSeq = action.Seq
+				player.AckTick = action.AckTick
+			default:
+				drained = true
+			}
+		}
+	}
+}
+
+// applyMove integrates a movement direction for one tick. The vector is
+// clamped to unit length so a client can't teleport by sending an
+// oversized move, unlike the old code which assigned the reported
+// position directly.
+func (r *Room) applyMove(player *Player, move Move, dt float64) {
+	dx, dy := move.X, move.Y
+	if dx == 0 && dy == 0 {
+		return
+	}
+	if mag := math.Hypot(dx, dy); mag > 1 {
+		dx /= mag
+		dy /= mag
+	}
+	player.X += dx * playerSpeed * dt
+	player.Y += dy * playerSpeed * dt
+	player.lastChangedTick = r.Tick
+}
+
+// applyShoot spawns a projectile for this tick's shot, if any.
+func (r *Room) applyShoot(player *Player, shoot Shoot) {
+	if shoot.DX == 0 && shoot.DY == 0 {
+		return
+	}
+	projectileID := uuid.New().String()
+	r.Projectiles[projectileID] = &Projectile{
+		ID:              projectileID,
+		Owner:           player.ID,
+		X:               player.X,
+		Y:               player.Y,
+		DX:              shoot.DX,
+		DY:              shoot.DY,
+		Speed:           15,
+		Expiry:          time.Now().Add(projectileLifetime),
+		lastChangedTick: r.Tick,
+	}
+}
+
+// advanceProjectiles moves projectiles and resolves collisions. Must be
+// called with r.Mutex held.
+func (r *Room) advanceProjectiles() {
+	now := time.Now()
+	for id, proj := range r.Projectiles {
+		if now.After(proj.Expiry) {
+			delete(r.Projectiles, id) // Remove expired projectiles
+			r.removedProjectiles = append(r.removedProjectiles, removal{ID: id, Tick: r.Tick})
+			continue
+		}
+		proj.X += proj.DX * proj.Speed
+		proj.Y += proj.DY * proj.Speed
+		proj.lastChangedTick = r.Tick
+		for _, player := range r.Players {
+			if player.ID == proj.Owner {
+				continue
+			}
+			if player.X-40 < proj.X && proj.X < player.X+40 && player.Y-40 < proj.Y && proj.Y < player.Y+40 {
+				delete(r.Projectiles, id)
+				r.removedProjectiles = append(r.removedProjectiles, removal{ID: id, Tick: r.Tick})
+				killer := r.Players[proj.Owner]
+				r.Mode.OnPlayerKilled(r, player, killer)
+				player.lastChangedTick = r.Tick
+				if killer != nil {
+					killer.lastChangedTick = r.Tick
+				}
+				break
+			}
+		}
+	}
+}
+
+// pruneRemovals drops removal tombstones old enough that no connection
+// could plausibly still need them. Must be called with r.Mutex held.
+func (r *Room) pruneRemovals() {
+	if r.Tick <= removalRetentionTicks {
+		return
+	}
+	cutoff := r.Tick - removalRetentionTicks
+	r.removedPlayers = pruneOlderThan(r.removedPlayers, cutoff)
+	r.removedProjectiles = pruneOlderThan(r.removedProjectiles, cutoff)
+}
+
+func pruneOlderThan(removals []removal, cutoff uint64) []removal {
+	kept := removals[:0]
+	for _, rm := range removals {
+		if rm.Tick > cutoff {
+			kept = append(kept, rm)
+		}
+	}
+	return kept
+}
+
+// snapshotDelta is what a single connection receives for one tick: only
+// the entities that changed since that connection's last acked tick, plus
+// any that were removed since then. Must be built with r.Mutex held.
+type snapshotDelta struct {
+	Tick               uint64       `json:"tick"`
+	Players            []Player     `json:"players"`
+	Projectiles        []Projectile `json:"projectiles"`
+	RemovedPlayers     []string     `json:"removedPlayers,omitempty"`
+	RemovedProjectiles []string     `json:"removedProjectiles,omitempty"`
+}
+
+// snapshotFor computes the delta for a connection that has acked ackTick.
+// A connection that has never acked (ackTick 0) gets every entity that has
+// ever changed, which in practice means everything currently in the room.
+func (r *Room) snapshotFor(ackTick uint64) snapshotDelta {
+	delta := snapshotDelta{Tick: r.Tick}
+	for _, player := range r.Players {
+		if player.lastChangedTick > ackTick {
+			delta.Players = append(delta.Players, *player)
+		}
+	}
+	for _, proj := range r.Projectiles {
+		if proj.lastChangedTick > ackTick {
+			delta.Projectiles = append(delta.Projectiles, *proj)
+		}
+	}
+	for _, rm := range r.removedPlayers {
+		if rm.Tick > ackTick {
+			delta.RemovedPlayers = append(delta.RemovedPlayers, rm.ID)
+		}
+	}
+	for _, rm := range r.removedProjectiles {
+		if rm.Tick > ackTick {
+			delta.RemovedProjectiles = append(delta.RemovedProjectiles, rm.ID)
+		}
+	}
+	return delta
+}
+
+// broadcastSnapshot sends each connection its own delta snapshot, encoded
+// as binary or JSON depending on the subprotocol it negotiated.
+func (r *Room) broadcastSnapshot() {
+	type outgoing struct {
+		conn   *websocket.Conn
+		player *Player
+		binary bool
+		delta  snapshotDelta
+	}
+
+	r.Mutex.Lock()
+	out := make([]outgoing, 0, len(r.Conns))
+	for id, conn := range r.Conns {
+		player, ok := r.Players[id]
+		if !ok {
+			continue
+		}
+		out = append(out, outgoing{
+			conn:   conn,
+			player: player,
+			binary: conn.Subprotocol() == protocolBinary,
+			delta:  r.snapshotFor(player.AckTick),
+		})
+	}
+	r.Mutex.Unlock()
+
+	for _, o := range out {
+		var (
+			payload []byte
+			err     error
+		)
+		if o.binary {
+			payload = encodeBinary(o.delta)
+			err = o.conn.WriteMessage(websocket.BinaryMessage, payload)
+		} else {
+			payload, err = json.Marshal(o.delta)
+			if err == nil {
+				err = o.conn.WriteMessage(websocket.TextMessage, payload)
+			}
+		}
+		r.Bandwidth.RecordTx(len(payload))
+		if err != nil {
+			log.Println("Error broadcasting state:", err)
+			r.detachConn(o.player)
+		}
+	}
+}
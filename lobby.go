@@ -0,0 +1,167 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrRoomNotFound  = errors.New("room not found")
+	ErrRoomFull      = errors.New("room is full")
+	ErrBadPassphrase = errors.New("incorrect passphrase")
+)
+
+// Room is an isolated match: its own players, projectiles, and mutex so
+// state from one game never leaks into another.
+type Room struct {
+	ID         string
+	Name       string
+	MaxPlayers int
+	Passphrase string
+
+	Players     map[string]*Player         // by player ID, persists across reconnects
+	Tokens      map[string]string          // reconnect token -> player ID
+	Conns       map[string]*websocket.Conn // player ID -> live connection, absent while disconnected
+	Projectiles map[string]*Projectile
+	Mode        GameMode
+	Mutex       sync.Mutex
+
+	// Tick is the room's own tick counter, used to compute delta snapshots.
+	Tick               uint64
+	removedPlayers     []removal
+	removedProjectiles []removal
+
+	Bandwidth  BandwidthMeter
+	frameStats FrameStats
+
+	started bool
+
+	// pendingJoins counts connection attempts that have reserved a slot
+	// (passed the capacity check, or claimed a reconnect token) but haven't
+	// yet finished the WebSocket handshake. It's counted alongside
+	// len(Players) for capacity and emptiness checks so a slow Upgrade call
+	// can't let the room overshoot MaxPlayers or get garbage-collected out
+	// from under a join that's already been admitted. See reserve.
+	pendingJoins int
+	// pendingTokens marks a reconnect token as claimed by an in-flight
+	// reservation, so a second racing reconnect with the same token is
+	// rejected as a duplicate instead of also passing the (not yet live)
+	// connection check and silently stealing the slot.
+	pendingTokens map[string]bool
+}
+
+// NewRoom creates an empty room ready to accept players.
+func NewRoom(name string, maxPlayers int, passphrase string, mode GameMode) *Room {
+	if maxPlayers <= 0 {
+		maxPlayers = 8
+	}
+	if mode == nil {
+		mode = NewGameMode("lms", DefaultGameModeParams)
+	}
+	return &Room{
+		ID:            uuid.New().String(),
+		Name:          name,
+		MaxPlayers:    maxPlayers,
+		Passphrase:    passphrase,
+		Players:       make(map[string]*Player),
+		Tokens:        make(map[string]string),
+		Conns:         make(map[string]*websocket.Conn),
+		Projectiles:   make(map[string]*Projectile),
+		Mode:          mode,
+		pendingTokens: make(map[string]bool),
+	}
+}
+
+// checkPassphrase reports whether attempt unlocks the room. Rooms created
+// without a passphrase accept any attempt, including an empty one.
+func (r *Room) checkPassphrase(attempt string) bool {
+	if r.Passphrase == "" {
+		return true
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Passphrase), []byte(attempt)) == 1
+}
+
+// PlayerCount returns the number of players in the room, including
+// disconnected players still within their reconnect grace period.
+func (r *Room) PlayerCount() int {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	return len(r.Players)
+}
+
+// ensureRunning starts the room's authoritative tick loop the first time a
+// player joins, and restarts it if the room was previously drained to zero
+// players and run() returned. Guarded by r.Mutex so two connections racing
+// to join an idle room can't both spawn a tick loop.
+func (r *Room) ensureRunning() {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+	if r.started {
+		return
+	}
+	r.started = true
+	go r.run()
+}
+
+// Lobby is the registry of all active rooms.
+type Lobby struct {
+	Rooms map[string]*Room
+	Mutex sync.Mutex
+}
+
+// Global lobby, mirroring the old global gameState.
+var lobby = Lobby{Rooms: make(map[string]*Room)}
+
+// CreateRoom registers a new room and returns it.
+func (l *Lobby) CreateRoom(name string, maxPlayers int, passphrase string, mode GameMode) *Room {
+	room := NewRoom(name, maxPlayers, passphrase, mode)
+	l.Mutex.Lock()
+	l.Rooms[room.ID] = room
+	l.Mutex.Unlock()
+	log.Println("Room created:", room.ID, room.Name, "mode:", room.Mode.Name())
+	return room
+}
+
+// Get looks up a room by ID.
+func (l *Lobby) Get(id string) (*Room, bool) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	room, ok := l.Rooms[id]
+	return room, ok
+}
+
+// List returns a snapshot of all active rooms.
+func (l *Lobby) List() []*Room {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	rooms := make([]*Room, 0, len(l.Rooms))
+	for _, room := range l.Rooms {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// removeIfEmpty garbage-collects a room once its last player has left.
+// Emptiness is rechecked after the lobby lock is held, with locks always
+// acquired lobby-then-room, so a join that lands between the first check and
+// the delete can't cause a still-occupied room to be dropped from the lobby.
+// A room with a reservation in flight (reserve has admitted a join that
+// hasn't finished its handshake yet) also counts as non-empty, otherwise the
+// grace-period timer could delete the room out from under that join.
+func (l *Lobby) removeIfEmpty(room *Room) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	room.Mutex.Lock()
+	empty := len(room.Players) == 0 && room.pendingJoins == 0
+	room.Mutex.Unlock()
+	if !empty {
+		return
+	}
+	delete(l.Rooms, room.ID)
+	log.Println("Room garbage-collected:", room.ID)
+}
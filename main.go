@@ -1,24 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
-	"sync"
+	"runtime"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-// Player represents a connected player
-type Player struct {
-	ID   string  `json:"id"`
-	X    float64 `json:"x"`
-	Y    float64 `json:"y"`
-	Dead bool    `json:"dead"`
-	Conn *websocket.Conn
-}
-
 type Projectile struct {
 	ID     string  `json:"id"`
 	Owner  string  `json:"owner"`
@@ -28,6 +20,8 @@ type Projectile struct {
 	DY     float64 `json:"dy"`
 	Speed  float64 `json:"speed"`
 	Expiry time.Time
+
+	lastChangedTick uint64
 }
 
 type Move struct {
@@ -41,188 +35,299 @@ type Shoot struct {
 }
 
 type ClientAction struct {
-	Move  Move  `json:"move"`
-	Shoot Shoot `json:"shoot"`
+	Seq   uint64 `json:"seq"`
+	Move  Move   `json:"move"`
+	Shoot Shoot  `json:"shoot"`
+
+	// AckTick is the highest snapshot tick this client has received, so
+	// the room can compute a per-connection delta rather than resending
+	// everything every tick.
+	AckTick uint64 `json:"ackTick"`
 }
 
-// GameState manages all players
-type GameState struct {
-	Players     map[string]*Player
-	Projectiles map[string]*Projectile
-	Mutex       sync.Mutex
+// WebSocket Upgrader
+var upgrader = websocket.Upgrader{
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: supportedSubprotocols,
 }
 
-// Global game state
-var gameState = GameState{
-	Players:     make(map[string]*Player),
-	Projectiles: make(map[string]*Projectile),
+// CreateGameRequest is the body of POST /games. Mode selects the room's
+// GameMode by name ("lms", "dm", "timed"); the params below apply only to
+// the modes that use them and fall back to DefaultGameModeParams when zero.
+type CreateGameRequest struct {
+	Name       string `json:"name"`
+	MaxPlayers int    `json:"maxPlayers"`
+	Passphrase string `json:"passphrase"`
+
+	Mode                string  `json:"mode"`
+	MaxPoints           int     `json:"maxPoints"`
+	TimeLimitSeconds    float64 `json:"timeLimitSeconds"`
+	RespawnDelaySeconds float64 `json:"respawnDelaySeconds"`
 }
 
-// WebSocket Upgrader
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
+// GameSummary is the public listing shape for a room; the passphrase itself
+// is never returned.
+type GameSummary struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Players       int    `json:"players"`
+	MaxPlayers    int    `json:"maxPlayers"`
+	HasPassphrase bool   `json:"hasPassphrase"`
+	Mode          string `json:"mode"`
 }
 
-// Handles new WebSocket connections
-func handleConn(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Error upgrading connection:", err)
+// JoinGameRequest is the body of POST /games/{id}/join
+type JoinGameRequest struct {
+	Passphrase string `json:"passphrase"`
+}
+
+func toSummary(room *Room) GameSummary {
+	return GameSummary{
+		ID:            room.ID,
+		Name:          room.Name,
+		Players:       room.PlayerCount(),
+		MaxPlayers:    room.MaxPlayers,
+		HasPassphrase: room.Passphrase != "",
+		Mode:          room.Mode.Name(),
+	}
+}
+
+// handleGames handles listing and creating rooms.
+func handleGames(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rooms := lobby.List()
+		summaries := make([]GameSummary, 0, len(rooms))
+		for _, room := range rooms {
+			summaries = append(summaries, toSummary(room))
+		}
+		json.NewEncoder(w).Encode(summaries)
+
+	case http.MethodPost:
+		var req CreateGameRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		params := DefaultGameModeParams
+		if req.MaxPoints > 0 {
+			params.MaxPoints = req.MaxPoints
+		}
+		if req.TimeLimitSeconds > 0 {
+			params.TimeLimit = time.Duration(req.TimeLimitSeconds * float64(time.Second))
+		}
+		if req.RespawnDelaySeconds > 0 {
+			params.RespawnDelay = time.Duration(req.RespawnDelaySeconds * float64(time.Second))
+		}
+		mode := NewGameMode(req.Mode, params)
+
+		room := lobby.CreateRoom(req.Name, req.MaxPlayers, req.Passphrase, mode)
+		json.NewEncoder(w).Encode(toSummary(room))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleGameByID handles /games/{id}/join, /games/{id}/bw, and
+// /games/{id}/stats.
+func handleGameByID(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/games/")
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	roomID, action := parts[0], parts[1]
+
+	room, ok := lobby.Get(roomID)
+	if !ok {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
 		return
 	}
-	defer conn.Close()
 
-	playerID := uuid.New().String()
+	switch action {
+	case "join":
+		handleJoinGame(w, r, room)
+	case "bw":
+		handleGameBandwidth(w, r, room)
+	case "stats":
+		handleGameStats(w, r, room)
+	default:
+		http.NotFound(w, r)
+	}
+}
 
-	player := &Player{
-		Conn: conn,
-		ID:   playerID,
-		X:    50,
-		Y:    50,
+func handleJoinGame(w http.ResponseWriter, r *http.Request, room *Room) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Add player to game state
-	gameState.Mutex.Lock()
-	gameState.Players[player.ID] = player
-	gameState.Mutex.Unlock()
+	var req JoinGameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !room.checkPassphrase(req.Passphrase) {
+		http.Error(w, ErrBadPassphrase.Error(), http.StatusForbidden)
+		return
+	}
+	if room.PlayerCount() >= room.MaxPlayers {
+		http.Error(w, ErrRoomFull.Error(), http.StatusForbidden)
+		return
+	}
 
-	log.Println("Player connected:", player.ID)
+	json.NewEncoder(w).Encode(toSummary(room))
+}
 
-	// Send the player's ID to them
-	err = conn.WriteJSON(map[string]string{"id": playerID})
-	if err != nil {
-		log.Println("Error sending player ID:", err)
-		delete(gameState.Players, player.ID)
+func handleGameBandwidth(w http.ResponseWriter, r *http.Request, room *Room) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	json.NewEncoder(w).Encode(room.Bandwidth.Stats())
+}
 
-	// Listen for movement updates
-	for {
-		var msg ClientAction
-		err := conn.ReadJSON(&msg)
-		if err != nil {
-			log.Println("Error reading JSON:", err)
-			break
-		}
+func handleGameStats(w http.ResponseWriter, r *http.Request, room *Room) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
-		gameState.Mutex.Lock()
-		if move := msg.Move; move.X != 0 || move.Y != 0 {
-			player.X = float64(move.X)
-			player.Y = float64(move.Y)
-		}
-		if shoot := msg.Shoot; shoot.DX != 0 || shoot.DY != 0 {
-			projectileID := uuid.New().String()
-			projectile := &Projectile{
-				ID:     projectileID,
-				Owner:  player.ID,
-				X:      player.X,
-				Y:      player.Y,
-				DX:     float64(shoot.DX),
-				DY:     float64(shoot.DY),
-				Speed:  15,
-				Expiry: time.Now().Add(5 * time.Second),
-			}
-			gameState.Projectiles[projectileID] = projectile
-		}
-		gameState.Mutex.Unlock()
+	total, active, tick := room.snapshotCounts()
+	framesSent, tickOverruns, avgTick := room.frameStats.Snapshot()
+
+	json.NewEncoder(w).Encode(RoomStats{
+		ID:              room.ID,
+		Name:            room.Name,
+		Players:         total,
+		ActivePlayers:   active,
+		MaxPlayers:      room.MaxPlayers,
+		Tick:            tick,
+		FramesSent:      framesSent,
+		TickOverruns:    tickOverruns,
+		AvgTickDuration: avgTick.String(),
+		AvgTickMillis:   float64(avgTick.Microseconds()) / 1000,
+	})
+}
+
+// handleStats reports process-wide health across every room.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Remove player on disconnect
-	gameState.Mutex.Lock()
-	delete(gameState.Players, player.ID)
-	gameState.Mutex.Unlock()
-	log.Println("Player disconnected:", player.ID)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	rooms := lobby.List()
+	totalPlayers, activePlayers := 0, 0
+	for _, room := range rooms {
+		total, active, _ := room.snapshotCounts()
+		totalPlayers += total
+		activePlayers += active
+	}
+
+	json.NewEncoder(w).Encode(ServerStats{
+		UptimeSeconds: time.Since(serverStart).Seconds(),
+		Rooms:         len(rooms),
+		TotalPlayers:  totalPlayers,
+		ActivePlayers: activePlayers,
+		Goroutines:    runtime.NumGoroutine(),
+		MemAllocBytes: mem.Alloc,
+		MemSysBytes:   mem.Sys,
+	})
 }
 
-// Broadcast game state to all players
-func broadcastState() {
-	for {
-		time.Sleep(16 * time.Millisecond)
-
-		gameState.Mutex.Lock()
-		state := struct {
-			Players     map[string]Player     `json:"players"`
-			Projectiles map[string]Projectile `json:"projectiles"`
-		}{
-			Players:     make(map[string]Player),
-			Projectiles: make(map[string]Projectile),
-		}
+// Handles new WebSocket connections, routing them into the requested room
+// and reattaching to an existing player if a valid reconnect token is given.
+func handleConn(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room")
+	room, ok := lobby.Get(roomID)
+	if !ok {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !room.checkPassphrase(r.URL.Query().Get("passphrase")) {
+		http.Error(w, ErrBadPassphrase.Error(), http.StatusForbidden)
+		return
+	}
 
-		for id, player := range gameState.Players {
-			state.Players[id] = *player
-		}
+	res, duplicate, full, gone := lobby.reserve(room, r.URL.Query().Get("token"))
+	if gone {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if full {
+		http.Error(w, ErrRoomFull.Error(), http.StatusForbidden)
+		return
+	}
 
-		for id, projectile := range gameState.Projectiles {
-			state.Projectiles[id] = *projectile
-		}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Error upgrading connection:", err)
+		res.cancel()
+		return
+	}
+	defer conn.Close()
 
-		stillAlive := 0
-		for _, player := range gameState.Players {
-			if !player.Dead {
-				stillAlive++
-			}
-		}
+	if duplicate != nil {
+		log.Println("Rejecting duplicate connection for player:", duplicate.ID)
+		conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "already connected"),
+			time.Now().Add(time.Second))
+		return
+	}
 
-		gameState.Mutex.Unlock()
+	player := res.commit(conn)
+	room.ensureRunning()
 
-		// Send the state to all players
-		for _, player := range gameState.Players {
-			err := player.Conn.WriteJSON(state)
-			if err != nil {
-				delete(gameState.Players, player.ID)
-				log.Println("Error broadcasting state:", err)
-			}
-		}
+	log.Println("Player connected:", player.ID, "room:", room.ID)
 
-		if stillAlive == 1 {
-			gameState.Mutex.Lock()
-			time.Sleep(3 * time.Second)
-			for _, player := range gameState.Players {
-				player.Dead = false
-				player.X = 50
-				player.Y = 50
-			}
-			gameState.Mutex.Unlock()
-		}
+	// Send the player's ID and reconnect token to them
+	err = conn.WriteJSON(map[string]string{"id": player.ID, "token": player.Token})
+	if err != nil {
+		log.Println("Error sending player ID:", err)
+		room.detachConn(player)
+		return
 	}
-}
 
-func updateProjectiles() {
+	// Listen for inputs and queue them for the tick loop to apply; the
+	// connection goroutine never touches game state directly.
 	for {
-		time.Sleep(16 * time.Millisecond) // ~60 FPS
-
-		gameState.Mutex.Lock()
-		now := time.Now()
-
-		for id, proj := range gameState.Projectiles {
-			if now.After(proj.Expiry) {
-				delete(gameState.Projectiles, id) // Remove expired projectiles
-				continue
-			}
-			// Move projectile
-			proj.X += proj.DX * proj.Speed
-			proj.Y += proj.DY * proj.Speed
-			for _, player := range gameState.Players {
-				if player.ID == proj.Owner {
-					continue
-				}
-				if player.X-40 < proj.X && proj.X < player.X+40 && player.Y-40 < proj.Y && proj.Y < player.Y+40 {
-					delete(gameState.Projectiles, id)
-					player.Dead = true
-					break
-				}
-			}
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			log.Println("Error reading message:", err)
+			break
+		}
+		room.Bandwidth.RecordRx(len(data))
+
+		var msg ClientAction
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Println("Error decoding client action:", err)
+			continue
 		}
 
-		gameState.Mutex.Unlock()
+		select {
+		case player.inputs <- msg:
+		default:
+			log.Println("Dropping input, queue full for player:", player.ID)
+		}
 	}
+
+	// Disconnect: keep the player's state around for reconnectGrace in case
+	// they come back.
+	room.detachConn(player)
+	log.Println("Player disconnected:", player.ID)
 }
 
 func main() {
+	http.HandleFunc("/games", handleGames)
+	http.HandleFunc("/games/", handleGameByID)
 	http.HandleFunc("/ws", handleConn)
-
-	go broadcastState()
-	go updateProjectiles()
+	http.HandleFunc("/stats", handleStats)
 
 	log.Println("Game server running on ws://localhost:8080/ws")
 	err := http.ListenAndServe(":8080", nil)
@@ -0,0 +1,147 @@
+package main
+
+import "time"
+
+// LastManStandingMode is the original behavior: a hit eliminates a player
+// for the rest of the round, which ends the instant only one is left.
+type LastManStandingMode struct{}
+
+func (m *LastManStandingMode) Name() string { return "lms" }
+
+func (m *LastManStandingMode) OnPlayerJoin(r *Room, player *Player) {}
+
+func (m *LastManStandingMode) OnPlayerKilled(r *Room, victim, killer *Player) {
+	victim.Dead = true
+	victim.Deaths++
+	if killer != nil {
+		killer.Kills++
+	}
+}
+
+func (m *LastManStandingMode) OnTick(r *Room) (bool, string) {
+	if len(r.Players) < 2 {
+		return false, ""
+	}
+	var lastAlive *Player
+	alive := 0
+	for _, player := range r.Players {
+		if !player.Dead {
+			alive++
+			lastAlive = player
+		}
+	}
+	if alive == 1 {
+		return true, lastAlive.ID
+	}
+	return false, ""
+}
+
+func (m *LastManStandingMode) OnRoundEnd(r *Room) {
+	tick := r.nextChangeTick()
+	for _, player := range r.Players {
+		player.Dead = false
+		player.X = 50
+		player.Y = 50
+		player.lastChangedTick = tick
+	}
+}
+
+// DeathmatchMode never eliminates a player: a kill scores a point and the
+// victim respawns after RespawnDelay. The round ends once someone reaches
+// MaxPoints kills.
+type DeathmatchMode struct {
+	MaxPoints    int
+	RespawnDelay time.Duration
+}
+
+func (m *DeathmatchMode) Name() string { return "dm" }
+
+func (m *DeathmatchMode) OnPlayerJoin(r *Room, player *Player) {}
+
+func (m *DeathmatchMode) OnPlayerKilled(r *Room, victim, killer *Player) {
+	victim.Dead = true
+	victim.Deaths++
+	if killer != nil {
+		killer.Kills++
+	}
+	respawnAfter(r, victim, m.RespawnDelay)
+}
+
+func (m *DeathmatchMode) OnTick(r *Room) (bool, string) {
+	if m.MaxPoints <= 0 {
+		return false, ""
+	}
+	for _, player := range r.Players {
+		if player.Kills >= m.MaxPoints {
+			return true, player.ID
+		}
+	}
+	return false, ""
+}
+
+func (m *DeathmatchMode) OnRoundEnd(r *Room) {
+	tick := r.nextChangeTick()
+	for _, player := range r.Players {
+		player.Kills = 0
+		player.Deaths = 0
+		player.Dead = false
+		player.X = 50
+		player.Y = 50
+		player.lastChangedTick = tick
+	}
+}
+
+// TimedRoundMode runs for a fixed duration; whoever has the most kills
+// when time runs out wins.
+type TimedRoundMode struct {
+	TimeLimit    time.Duration
+	RespawnDelay time.Duration
+
+	roundStart time.Time
+}
+
+func (m *TimedRoundMode) Name() string { return "timed" }
+
+func (m *TimedRoundMode) OnPlayerJoin(r *Room, player *Player) {
+	if m.roundStart.IsZero() {
+		m.roundStart = time.Now()
+	}
+}
+
+func (m *TimedRoundMode) OnPlayerKilled(r *Room, victim, killer *Player) {
+	victim.Dead = true
+	victim.Deaths++
+	if killer != nil {
+		killer.Kills++
+	}
+	respawnAfter(r, victim, m.RespawnDelay)
+}
+
+func (m *TimedRoundMode) OnTick(r *Room) (bool, string) {
+	if m.roundStart.IsZero() || time.Since(m.roundStart) < m.TimeLimit {
+		return false, ""
+	}
+	var winner *Player
+	for _, player := range r.Players {
+		if winner == nil || player.Kills > winner.Kills {
+			winner = player
+		}
+	}
+	if winner == nil {
+		return true, ""
+	}
+	return true, winner.ID
+}
+
+func (m *TimedRoundMode) OnRoundEnd(r *Room) {
+	m.roundStart = time.Now()
+	tick := r.nextChangeTick()
+	for _, player := range r.Players {
+		player.Kills = 0
+		player.Deaths = 0
+		player.Dead = false
+		player.X = 50
+		player.Y = 50
+		player.lastChangedTick = tick
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// reconnectGrace is how long a disconnected player's state is kept around
+// so they can reattach with their token before being dropped for good.
+const reconnectGrace = 30 * time.Second
+
+// inputQueueSize bounds how many unprocessed inputs a player can have
+// queued for the tick loop; once full, new inputs are dropped rather than
+// let a slow-ticking room build up unbounded latency.
+const inputQueueSize = 32
+
+// Player represents a connected player's game state. It survives across
+// reconnects; the live network connection is tracked separately by Room
+// so identity isn't lost every time a socket drops.
+type Player struct {
+	ID   string  `json:"id"`
+	X    float64 `json:"x"`
+	Y    float64 `json:"y"`
+	Dead bool    `json:"dead"`
+
+	// Kills and Deaths are maintained by the room's GameMode.
+	Kills  int `json:"kills"`
+	Deaths int `json:"deaths"`
+
+	// LastAckedSeq is the sequence number of the last input the tick loop
+	// applied for this player, so clients can reconcile their prediction.
+	LastAckedSeq uint64 `json:"lastAckedSeq"`
+
+	Token string `json:"-"`
+
+	// AckTick is the last tick this player's connection has confirmed
+	// receiving; the tick loop uses it to compute what's changed since.
+	AckTick uint64 `json:"-"`
+	// lastChangedTick is the tick this player's state last changed, used
+	// the same way on the sending side of the delta.
+	lastChangedTick uint64
+
+	inputs       chan ClientAction
+	removalTimer *time.Timer
+}
+
+// newPlayerLocked creates and registers a fresh player with a new reconnect
+// token. Must be called with r.Mutex held.
+func (r *Room) newPlayerLocked() *Player {
+	player := &Player{
+		ID:              uuid.New().String(),
+		Token:           uuid.New().String(),
+		X:               50,
+		Y:               50,
+		lastChangedTick: r.nextChangeTick(),
+		inputs:          make(chan ClientAction, inputQueueSize),
+	}
+	r.Players[player.ID] = player
+	r.Tokens[player.Token] = player.ID
+	r.Mode.OnPlayerJoin(r, player)
+	return player
+}
+
+// reservation is a slot claimed on a room for one in-flight connection
+// attempt, returned by Lobby.reserve. The caller must resolve it exactly
+// once, via commit once the WebSocket handshake succeeds or cancel if it
+// doesn't, so the room's occupancy bookkeeping stays accurate across the
+// slow Upgrade call in between.
+type reservation struct {
+	room   *Room
+	player *Player // non-nil only when reattaching to an existing player
+	token  string  // non-empty only when the reservation claimed a token
+}
+
+// reserve atomically admits one connection attempt into room: a reconnect
+// if token resolves to an existing player, otherwise a fresh join. duplicate
+// is non-nil when token belongs to a player that already has a live
+// connection or another reservation already in flight for it, in which
+// case the caller should reject the new one rather than steal it. full
+// reports that the room has no free slot once pending reservations are
+// counted alongside live players. gone reports that room was already
+// garbage-collected from the lobby, which can happen if the caller is
+// acting on a *Room obtained from an earlier lobby.Get.
+//
+// This is a Lobby method rather than a Room one because it must recheck
+// room's lobby membership under the same lobby-then-room lock order
+// removeIfEmpty uses; otherwise a grace-period GC racing this reservation
+// could delete the room in between, leaving it running but unreachable.
+func (l *Lobby) reserve(room *Room, token string) (res reservation, duplicate *Player, full, gone bool) {
+	l.Mutex.Lock()
+	defer l.Mutex.Unlock()
+	if l.Rooms[room.ID] != room {
+		return reservation{}, nil, false, true
+	}
+
+	room.Mutex.Lock()
+	defer room.Mutex.Unlock()
+
+	if token != "" {
+		if playerID, ok := room.Tokens[token]; ok {
+			player := room.Players[playerID]
+			if _, live := room.Conns[playerID]; live || room.pendingTokens[token] {
+				return reservation{}, player, false, false
+			}
+			room.pendingTokens[token] = true
+			room.pendingJoins++
+			return reservation{room: room, player: player, token: token}, nil, false, false
+		}
+	}
+
+	if len(room.Players)+room.pendingJoins >= room.MaxPlayers {
+		return reservation{}, nil, true, false
+	}
+	room.pendingJoins++
+	return reservation{room: room}, nil, false, false
+}
+
+// cancel releases a reservation that never completed its handshake (the
+// Upgrade call failed, or the caller rejected the connection for another
+// reason). Safe to call on a zero-value reservation.
+func (res reservation) cancel() {
+	if res.room == nil {
+		return
+	}
+	res.room.Mutex.Lock()
+	res.room.pendingJoins--
+	if res.token != "" {
+		delete(res.room.pendingTokens, res.token)
+	}
+	res.room.Mutex.Unlock()
+}
+
+// commit finalizes a reservation once the connection is live: it attaches
+// conn to the reserved player (creating one for a fresh join), cancels any
+// pending removal timer, resets AckTick so the connection gets a full
+// resync, and clears the in-flight bookkeeping. AckTick is reset because
+// removal tombstones are pruned after removalRetentionTicks, which is
+// shorter than reconnectGrace, so a stale AckTick from before the
+// disconnect could miss them and leave a ghost entity with no way to ever
+// learn it's gone.
+func (res reservation) commit(conn *websocket.Conn) *Player {
+	r := res.room
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	player := res.player
+	if player == nil {
+		player = r.newPlayerLocked()
+	}
+	if res.token != "" {
+		delete(r.pendingTokens, res.token)
+	}
+	r.pendingJoins--
+
+	if player.removalTimer != nil {
+		player.removalTimer.Stop()
+		player.removalTimer = nil
+	}
+	r.Conns[player.ID] = conn
+	player.AckTick = 0
+	return player
+}
+
+// detachConn drops the live connection and starts the grace-period timer
+// before the player is actually removed from the game.
+func (r *Room) detachConn(player *Player) {
+	r.Mutex.Lock()
+	delete(r.Conns, player.ID)
+	player.removalTimer = time.AfterFunc(reconnectGrace, func() {
+		r.removePlayer(player.ID)
+	})
+	r.Mutex.Unlock()
+}
+
+// removePlayer fully drops a player who never reconnected within the grace
+// period, and garbage-collects the room if that leaves it empty.
+func (r *Room) removePlayer(playerID string) {
+	r.Mutex.Lock()
+	if player, ok := r.Players[playerID]; ok {
+		if _, live := r.Conns[playerID]; !live {
+			delete(r.Players, playerID)
+			delete(r.Tokens, player.Token)
+			r.removedPlayers = append(r.removedPlayers, removal{ID: playerID, Tick: r.nextChangeTick()})
+			log.Println("Player removed after grace period:", playerID)
+		}
+	}
+	r.Mutex.Unlock()
+	lobby.removeIfEmpty(r)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// serverStart marks process start, for the /stats uptime figure.
+var serverStart = time.Now()
+
+// FrameStats is written by a room's tick loop and read by its stats
+// handler; the fields are only ever touched via atomic ops so the handler
+// never has to take the room's mutex to see them.
+type FrameStats struct {
+	framesSent     uint64
+	tickOverruns   uint64
+	totalTickNanos uint64
+}
+
+// record logs one completed tick's wall-clock duration.
+func (f *FrameStats) record(d time.Duration) {
+	atomic.AddUint64(&f.framesSent, 1)
+	atomic.AddUint64(&f.totalTickNanos, uint64(d.Nanoseconds()))
+	if d > tickInterval {
+		atomic.AddUint64(&f.tickOverruns, 1)
+	}
+}
+
+// Snapshot reads the counters and derives the average tick duration.
+func (f *FrameStats) Snapshot() (framesSent, tickOverruns uint64, avgTick time.Duration) {
+	framesSent = atomic.LoadUint64(&f.framesSent)
+	tickOverruns = atomic.LoadUint64(&f.tickOverruns)
+	if framesSent > 0 {
+		avgTick = time.Duration(atomic.LoadUint64(&f.totalTickNanos) / framesSent)
+	}
+	return framesSent, tickOverruns, avgTick
+}
+
+// ServerStats is the GET /stats response payload, modeled on the kind of
+// node-health payload an audio server exposes: connection counts, uptime,
+// and Go runtime health.
+type ServerStats struct {
+	UptimeSeconds float64 `json:"uptimeSeconds"`
+	Rooms         int     `json:"rooms"`
+	TotalPlayers  int     `json:"totalPlayers"`
+	ActivePlayers int     `json:"activePlayers"`
+	Goroutines    int     `json:"goroutines"`
+	MemAllocBytes uint64  `json:"memAllocBytes"`
+	MemSysBytes   uint64  `json:"memSysBytes"`
+}
+
+// RoomStats is the GET /games/{id}/stats response payload.
+type RoomStats struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	Players         int     `json:"players"`
+	ActivePlayers   int     `json:"activePlayers"`
+	MaxPlayers      int     `json:"maxPlayers"`
+	Tick            uint64  `json:"tick"`
+	FramesSent      uint64  `json:"framesSent"`
+	TickOverruns    uint64  `json:"tickOverruns"`
+	AvgTickDuration string  `json:"avgTickDuration"`
+	AvgTickMillis   float64 `json:"avgTickMillis"`
+}